@@ -0,0 +1,48 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"hash"
+	"sort"
+	"strings"
+
+	"github.com/cespare/xxhash/v2"
+	"golang.org/x/crypto/blake2b"
+	"lukechampine.com/blake3"
+)
+
+// hashAlgorithms maps a -hash flag value to a constructor for that
+// algorithm's hash.Hash. sha256 is the default, so the tool's behavior is
+// unchanged unless a faster, non-cryptographic algorithm is asked for.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"sha512": sha512.New,
+	"blake2b": func() hash.Hash {
+		h, _ := blake2b.New256(nil) // nil key, can't fail
+		return h
+	},
+	"blake3": func() hash.Hash { return blake3.New(32, nil) },
+	"xxhash": func() hash.Hash { return xxhash.New() },
+}
+
+// newHasher resolves a -hash flag value to a hash.Hash constructor.
+func newHasher(name string) (func() hash.Hash, error) {
+	ctor, ok := hashAlgorithms[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown hash algorithm %q (valid: %s)", name, validHashNames())
+	}
+	return ctor, nil
+}
+
+func validHashNames() string {
+	names := make([]string, 0, len(hashAlgorithms))
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestNewHasherKnownAlgorithms(t *testing.T) {
+	for name := range hashAlgorithms {
+		ctor, err := newHasher(name)
+		if err != nil {
+			t.Errorf("newHasher(%q): unexpected error: %v", name, err)
+			continue
+		}
+		h := ctor()
+		if _, err := h.Write([]byte("duplicatefinder")); err != nil {
+			t.Errorf("%s: Write: %v", name, err)
+		}
+		if len(h.Sum(nil)) == 0 {
+			t.Errorf("%s: Sum returned no bytes", name)
+		}
+	}
+}
+
+func TestNewHasherUnknownAlgorithm(t *testing.T) {
+	if _, err := newHasher("md5"); err == nil {
+		t.Fatal("expected an error for an unregistered hash algorithm")
+	}
+}
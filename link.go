@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// starts a loop that receives duplicate/original pairs and replaces each
+// duplicate with a hardlink to the retained original, reclaiming its space
+// without losing the path. Duplicates already hardlinked to their original
+// are left alone, and a duplicate on a different filesystem (where
+// os.Link can't cross devices) falls back to a symlink.
+func startLinker(ctx context.Context, dryRun bool, dupeQueue chan dupePair, linkedSumResult chan fileCounter) {
+	go func() {
+		var count, size int64 // for counting the reclaimed files
+		for pair := range dupeQueue {
+			if sameFile(pair.duplicate.path, pair.original.path) {
+				continue // already hardlinked, nothing to reclaim
+			}
+
+			if dryRun { // if we are not actually doing it
+				fmt.Println("*Linking file:", pair.duplicate.path, "->", pair.original.path)
+			} else if err := linkDuplicate(pair.duplicate.path, pair.original.path); err != nil {
+				fmt.Println("error linking duplicate:", pair.duplicate.path)
+				fmt.Println(err)
+				continue
+			}
+
+			count++
+			size += pair.duplicate.size
+		}
+		// pump result to channel
+		linkedSumResult <- fileCounter{count: count, size: size}
+	}()
+}
+
+// linkDuplicate replaces path with a hardlink to original, falling back to
+// a symlink only when they're genuinely on different filesystems (detected
+// up front via syscall.Stat_t.Dev, the same check sameFile uses) since
+// os.Link can't cross devices. Any other Link failure - permissions, a
+// read-only remount, too many links - is returned as-is rather than masked
+// by a symlink fallback. The replacement is built under a temporary name
+// and moved over path with os.Rename, so a failed Link/Symlink never
+// leaves path deleted.
+func linkDuplicate(path, original string) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, ".dflink-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	os.Remove(tmpPath) // only wanted a unique name for Link/Symlink to create
+
+	cross, err := crossDevice(original, dir)
+	if err != nil {
+		return err
+	}
+
+	if cross {
+		rel, err := filepath.Rel(dir, original)
+		if err != nil {
+			rel = original // couldn't make it relative, fall back to the path as given
+		}
+		if err := os.Symlink(rel, tmpPath); err != nil {
+			return err
+		}
+		if err := os.Rename(tmpPath, path); err != nil {
+			os.Remove(tmpPath)
+			return err
+		}
+		fmt.Println("Symlinked (cross-device):", path, "->", original)
+		return nil
+	}
+
+	if err := os.Link(original, tmpPath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+
+	fmt.Println("Linked:", path, "->", original)
+	return nil
+}
+
+// crossDevice reports whether a and b live on different filesystems, using
+// the same syscall.Stat_t.Dev comparison as sameFile.
+func crossDevice(a, b string) (bool, error) {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false, err
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false, err
+	}
+
+	statA, ok := infoA.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, fmt.Errorf("cannot determine device for %s", a)
+	}
+	statB, ok := infoB.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false, fmt.Errorf("cannot determine device for %s", b)
+	}
+
+	return statA.Dev != statB.Dev, nil
+}
+
+// sameFile reports whether a and b are already the same hardlinked file, so
+// the linker doesn't replace a link that's already in place.
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	statA, ok := infoA.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	statB, ok := infoB.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+
+	return statA.Dev == statB.Dev && statA.Ino == statB.Ino
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLinkDuplicateSameDevice(t *testing.T) {
+	dir := t.TempDir()
+	original := filepath.Join(dir, "original")
+	duplicate := filepath.Join(dir, "duplicate")
+
+	if err := os.WriteFile(original, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(duplicate, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if sameFile(duplicate, original) {
+		t.Fatal("sameFile reported true before linking")
+	}
+
+	if err := linkDuplicate(duplicate, original); err != nil {
+		t.Fatalf("linkDuplicate: %v", err)
+	}
+
+	if !sameFile(duplicate, original) {
+		t.Fatal("sameFile reported false after linking")
+	}
+
+	info, err := os.Lstat(duplicate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		t.Fatal("duplicate was symlinked instead of hardlinked on the same device")
+	}
+}
+
+func TestLinkDuplicatePreservesOriginalOnLinkFailure(t *testing.T) {
+	dir := t.TempDir()
+	duplicate := filepath.Join(dir, "duplicate")
+	if err := os.WriteFile(duplicate, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := filepath.Join(dir, "does-not-exist")
+	if err := linkDuplicate(duplicate, missing); err == nil {
+		t.Fatal("expected an error linking to a nonexistent original")
+	}
+
+	if _, err := os.Stat(duplicate); err != nil {
+		t.Fatalf("duplicate should still exist after a failed link: %v", err)
+	}
+}
+
+func TestCrossDeviceSameFilesystem(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	if err := os.WriteFile(a, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(b, []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cross, err := crossDevice(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cross {
+		t.Fatal("crossDevice reported true for two files on the same filesystem")
+	}
+}
@@ -1,153 +1,480 @@
-package main
-
-import (
-	"crypto/sha256"
-	"encoding/base64"
-	"flag"
-	"fmt"
-	"io"
-	"os"
-	"path/filepath"
-	"sync"
-)
-
-type fileContainer struct {
-	path string
-	hash string
-	size int64
-}
-
-type fileCounter struct {
-	count int64
-	size  int64
-}
-
-func main() {
-	dryRun := flag.Bool("dry", false, "")
-	flag.Parse()
-
-	scanningQueue := make(chan fileContainer) // for files to be scanned
-	deleteQueue := make(chan fileContainer)   // for files to be deleted
-	sumQueue := make(chan fileContainer)      // for files to be hashed
-
-	deletedSumResult := make(chan fileCounter) // for results on deleted files
-	totalSumResult := make(chan fileCounter)   // for results on all files
-
-	// setup all the working parts
-	walkFn := createWalker(scanningQueue)                 // create filepath.WalkFunc
-	createFileScanners(8, scanningQueue, sumQueue)        // create scanners
-	startSumHolder(sumQueue, deleteQueue, totalSumResult) // create sum holder
-	startDeleter(*dryRun, deleteQueue, deletedSumResult)  // create delete loop
-
-	filepath.Walk(".", walkFn) // start walking
-
-	close(scanningQueue) // close scanning channel when done walking
-
-	deletedSum := <-deletedSumResult // wait for delete result
-	totalSum := <-totalSumResult     // wait for total result
-
-	// print stats, total scanned vs. deleted
-	fmt.Println("Total files scanned:", totalSum.count)
-	fmt.Println("Total filesize: ", totalSum.size)
-	fmt.Println("Deleted files:", deletedSum.count)
-	fmt.Println("Deleted size:", deletedSum.size)
-}
-
-// Creates the WalkFunc that is used by filepath.Walk
-func createWalker(scanningQueue chan fileContainer) filepath.WalkFunc {
-	return func(path string, info os.FileInfo, err error) error {
-		if !info.IsDir() { // check if it is a file
-			// if it is a file push the info to channel for scanning
-			scanningQueue <- fileContainer{path: path, size: info.Size()}
-		}
-		return nil
-	}
-}
-
-// Creates n scanners that take files from the scanning channel and creates
-// a (SHA256) hash of their content before putting them on the channel of
-// hashed files
-func createFileScanners(n int, scanningQeueue chan fileContainer, sumQueue chan fileContainer) {
-	var wg sync.WaitGroup    // count the number of scanners we have
-	for i := 0; i < n; i++ { // create n scanners
-		wg.Add(1) // increase active scanner count
-		go func() {
-			for file := range scanningQeueue {
-				fp, err := os.Open(file.path)
-				if err != nil {
-					fmt.Println("error opening file:", file)
-					fmt.Println(err)
-					continue // we proceede to next file on error
-				}
-
-				hash := sha256.New()
-				if _, err := io.Copy(hash, fp); err != nil {
-					fmt.Println("error creating hash for file:", file)
-					fmt.Println(err)
-					continue // we proceede to next file on error
-				}
-
-				fp.Close()
-
-				sum := hash.Sum(nil)
-				encodedSum := base64.StdEncoding.EncodeToString(sum)
-				file.hash = encodedSum
-				sumQueue <- file
-			}
-			wg.Done() // decrease when this scanner is done
-		}()
-	}
-	go func() {
-		wg.Wait()       // wait for all active scanners to finish
-		close(sumQueue) // close the channel of sums when done
-	}()
-}
-
-// starts the function that holds the sums of files and push duplicates.
-// having a single loop in a single function removes data race
-func startSumHolder(sumQueue chan fileContainer, deleteQueue chan fileContainer, totalSumResult chan fileCounter) {
-	go func() {
-		var count, size int64                  // used for counting all files
-		sums := make(map[string]fileContainer) // holds all sums
-		for container := range sumQueue {
-			count++
-			size += container.size
-
-			if p1, found := sums[container.hash]; found { // if we already know the hash
-				dir1 := filepath.Dir(p1.path)
-				dir2 := filepath.Dir(container.path)
-
-				if len(dir1) > len(dir2) { // delete the one with the longest path
-					deleteQueue <- p1
-				} else {
-					deleteQueue <- container
-					container = p1
-				}
-			}
-
-			sums[container.hash] = container // store the hash in the map
-		}
-		// when done (the sum channel has been closed)
-		close(deleteQueue)                                      // close the delete channel
-		totalSumResult <- fileCounter{count: count, size: size} // and send result on channel
-	}()
-}
-
-// starts a loop that receives files for deletion.
-func startDeleter(dryRun bool, deleteQueue chan fileContainer, deletedSumResult chan fileCounter) {
-	go func() {
-		var count, size int64 // for counting the deleted files
-		for fileToDelete := range deleteQueue {
-			count++
-			size += fileToDelete.size
-			if !dryRun { // if we are actually doing it
-				fmt.Println("Deleting file:", fileToDelete.path)
-				os.Remove(fileToDelete.path)
-			} else { // if we are not actually deleting
-				fmt.Println("*Deleting file:", fileToDelete.path)
-			}
-		}
-		// pump result to channel
-		deletedSumResult <- fileCounter{count: count, size: size}
-	}()
-}
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// copyChunkSize is how many bytes we read between checks of ctx.Done() while
+// hashing a file, so a cancelled scan doesn't keep reading a huge file to
+// the end before noticing.
+const copyChunkSize = 32 * 1024
+
+// partialHashSize is how many bytes we read from the start and the end of a
+// file for the cheap partial hash used to narrow down same-size candidates
+// before anyone pays for a full hash.
+const partialHashSize = 64 * 1024
+
+type fileContainer struct {
+	path string
+	hash string
+	size int64
+}
+
+type fileCounter struct {
+	count int64
+	size  int64
+}
+
+// dupePair is a duplicate found by the sum holder together with the
+// original it's a copy of - the one the deleter or linker keeps.
+type dupePair struct {
+	duplicate fileContainer
+	original  fileContainer
+}
+
+func main() {
+	dryRun := flag.Bool("dry", false, "")
+	linkMode := flag.Bool("link", false, "replace duplicates with hardlinks to the retained copy instead of deleting them")
+	hashName := flag.String("hash", "sha256", "hash algorithm to use: "+validHashNames())
+	reportPath := flag.String("report", "", "write a newline-delimited JSON manifest of duplicate groups to this file")
+	applyPath := flag.String("apply", "", "re-run deletions (or -link) from a manifest written with -report, without rescanning")
+	minSize := flag.Int64("min-size", 0, "skip files smaller than this many bytes")
+	maxSize := flag.Int64("max-size", 0, "skip files larger than this many bytes (0 means no limit)")
+	followSymlinks := flag.Bool("follow-symlinks", false, "follow symlinked directories instead of skipping them")
+	progressInterval := flag.Duration("progress-interval", 2*time.Second, "how often to print a progress snapshot (files walked, bytes hashed, throughput, ETA)")
+
+	var cliPatterns []pattern
+	flag.Var(&patternFlag{&cliPatterns, false}, "exclude", "glob pattern (gitignore-style, repeatable) of paths to skip")
+	flag.Var(&patternFlag{&cliPatterns, true}, "include", "glob pattern (gitignore-style, repeatable) re-including paths an -exclude or .dfignore would otherwise skip")
+
+	flag.Parse()
+
+	if *applyPath != "" {
+		if err := runApply(*applyPath, *dryRun, *linkMode); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	hashCtor, err := newHasher(*hashName)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	prog := &progress{}
+	progressSig := make(chan os.Signal, 1)
+	signal.Notify(progressSig, syscall.SIGUSR1) // immediate snapshot on demand
+	defer signal.Stop(progressSig)
+	startProgressReporter(ctx, prog, *progressInterval, progressSig)
+
+	scanningQueue := make(chan fileContainer)   // for files found during the walk
+	partialSumQueue := make(chan fileContainer) // for files with a partial hash
+	sumQueue := make(chan fileContainer)        // for files with a full hash
+	dupeQueue := make(chan dupePair)            // for duplicate/original pairs found
+	reportQueue := make(chan duplicateGroup)    // for duplicate groups to report
+
+	dedupedSumResult := make(chan fileCounter) // for results on deleted or linked files
+	reportResult := make(chan error)           // for the result of writing the report
+
+	walkOpts := walkOptions{
+		matcher:        (&matcher{}).withDfignore("."),
+		cliPatterns:    cliPatterns,
+		minSize:        *minSize,
+		maxSize:        *maxSize,
+		followSymlinks: *followSymlinks,
+		progress:       prog,
+	}
+
+	// setup all the working parts
+	walkFn := createWalker(ctx, walkOpts, scanningQueue)                           // create the walk function
+	candidateQueue, totalSumResult := createSizeBucketer(ctx, scanningQueue, prog) // group by size, drop unique sizes
+	createFileScanners(ctx, 8, candidateQueue, partialSumQueue, hashCtor, prog)    // partial hash size-bucket candidates
+	resolveQueue := createCollisionResolver(ctx, partialSumQueue, prog)            // group by partial hash, drop unique ones
+	createFullHashScanners(ctx, 8, resolveQueue, sumQueue, hashCtor, prog)         // full hash the remaining collisions
+	startSumHolder(ctx, sumQueue, dupeQueue, reportQueue)                          // find true duplicates, queue for dedup/report
+	startReporter(reportQueue, *reportPath, reportResult)                          // write the duplicate manifest
+	if *linkMode {
+		startLinker(ctx, *dryRun, dupeQueue, dedupedSumResult) // replace duplicates with hardlinks
+	} else {
+		startDeleter(ctx, *dryRun, dupeQueue, dedupedSumResult) // remove duplicates
+	}
+
+	if err := walkFn(); err != nil && err != context.Canceled {
+		fmt.Println("walk stopped:", err)
+	}
+
+	close(scanningQueue) // close scanning channel when done walking
+
+	dedupedSum := <-dedupedSumResult // wait for delete/link result
+	totalSum := <-totalSumResult     // wait for total result
+	if err := <-reportResult; err != nil {
+		fmt.Println("error writing report:", err)
+	}
+
+	// print stats, total scanned vs. deleted/linked
+	fmt.Println("Total files scanned:", totalSum.count)
+	fmt.Println("Total filesize: ", totalSum.size)
+	if *linkMode {
+		fmt.Println("Linked files:", dedupedSum.count)
+		fmt.Println("Bytes reclaimed:", dedupedSum.size)
+	} else {
+		fmt.Println("Deleted files:", dedupedSum.count)
+		fmt.Println("Deleted size:", dedupedSum.size)
+	}
+}
+
+// createSizeBucketer groups incoming files by size, without touching their
+// content, and forwards only the files whose size is shared by at least one
+// other file on candidateQueue - a file with a unique size can never be a
+// duplicate. It reports the total count and size of every file it saw (not
+// just the candidates) on totalResult once scanningQueue is closed. Once
+// the candidate set is known, it also adds their partial-hash cost to
+// prog.bytesToHash, since those are the only bytes the next stage will
+// actually read.
+func createSizeBucketer(ctx context.Context, scanningQueue chan fileContainer, prog *progress) (chan fileContainer, chan fileCounter) {
+	candidateQueue := make(chan fileContainer)
+	totalResult := make(chan fileCounter)
+
+	go func() {
+		var count, size int64
+		bySize := make(map[int64][]fileContainer)
+		for container := range scanningQueue {
+			count++
+			size += container.size
+			bySize[container.size] = append(bySize[container.size], container)
+		}
+
+		for _, group := range bySize {
+			if len(group) < 2 {
+				continue // unique size, can't have a duplicate
+			}
+			for _, container := range group {
+				prog.bytesToHash.Add(minInt64(2*partialHashSize, container.size))
+				select {
+				case <-ctx.Done():
+					close(candidateQueue)
+					totalResult <- fileCounter{count: count, size: size}
+					return
+				case candidateQueue <- container:
+				}
+			}
+		}
+
+		close(candidateQueue)
+		totalResult <- fileCounter{count: count, size: size}
+	}()
+
+	return candidateQueue, totalResult
+}
+
+// Creates n scanners that take size-bucket candidates from candidateQueue
+// and compute a cheap partial hash of their content, forwarding the result
+// on partialSumQueue. Files whose partial hash turns out to be unique
+// within their size group are filtered out by createCollisionResolver
+// before anyone pays for a full hash.
+func createFileScanners(ctx context.Context, n int, candidateQueue chan fileContainer, partialSumQueue chan fileContainer, hashCtor func() hash.Hash, prog *progress) {
+	var wg sync.WaitGroup    // count the number of scanners we have
+	for i := 0; i < n; i++ { // create n scanners
+		wg.Add(1) // increase active scanner count
+		go func() {
+			defer wg.Done() // decrease when this scanner is done
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case file, ok := <-candidateQueue:
+					if !ok {
+						return
+					}
+
+					fp, err := os.Open(file.path)
+					if err != nil {
+						fmt.Println("error opening file:", file)
+						fmt.Println(err)
+						continue // we proceede to next file on error
+					}
+
+					sum, err := partialHash(hashCtor, fp, file.size)
+					fp.Close()
+					if err != nil {
+						fmt.Println("error creating partial hash for file:", file)
+						fmt.Println(err)
+						continue // we proceede to next file on error
+					}
+					prog.bytesHashed.Add(minInt64(2*partialHashSize, file.size))
+
+					file.hash = base64.StdEncoding.EncodeToString(sum)
+
+					select {
+					case <-ctx.Done():
+						return
+					case partialSumQueue <- file:
+					}
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()              // wait for all active scanners to finish
+		close(partialSumQueue) // close the channel of partial sums when done
+	}()
+}
+
+// partialHash hashes up to partialHashSize bytes from the start and the end
+// of the file. Files with different partial hashes can never be identical,
+// so only partial-hash collisions need to go through the expensive full
+// hash pass.
+func partialHash(hashCtor func() hash.Hash, fp *os.File, size int64) ([]byte, error) {
+	h := hashCtor()
+
+	head := make([]byte, minInt64(partialHashSize, size))
+	if _, err := io.ReadFull(fp, head); err != nil {
+		return nil, err
+	}
+	h.Write(head)
+
+	if size > partialHashSize {
+		tail := make([]byte, partialHashSize)
+		if _, err := fp.ReadAt(tail, size-partialHashSize); err != nil {
+			return nil, err
+		}
+		h.Write(tail)
+	}
+
+	return h.Sum(nil), nil
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// createCollisionResolver groups partial-hash results by size and partial
+// hash, forwarding only the files that still collide with at least one
+// other file to resolveQueue for a full hash. A full hash is the only way
+// to be sure two partial-hash matches are actually identical. Once
+// partialSumQueue is closed, every file that will ever need a full hash is
+// known, so this is also where prog.bytesToHash gets its last addition
+// (each collision's full size) and prog.hashTotalKnown is set - the
+// earliest point the ETA's denominator is complete.
+func createCollisionResolver(ctx context.Context, partialSumQueue chan fileContainer, prog *progress) chan fileContainer {
+	resolveQueue := make(chan fileContainer)
+
+	go func() {
+		groups := make(map[string][]fileContainer)
+		for container := range partialSumQueue {
+			key := fmt.Sprintf("%d:%s", container.size, container.hash)
+			groups[key] = append(groups[key], container)
+		}
+
+		for _, group := range groups {
+			if len(group) < 2 {
+				continue // partial hash didn't collide, not a duplicate
+			}
+			for _, container := range group {
+				prog.bytesToHash.Add(container.size)
+			}
+		}
+		prog.hashTotalKnown.Store(true)
+
+		for _, group := range groups {
+			if len(group) < 2 {
+				continue // partial hash didn't collide, not a duplicate
+			}
+			for _, container := range group {
+				select {
+				case <-ctx.Done():
+					close(resolveQueue)
+					return
+				case resolveQueue <- container:
+				}
+			}
+		}
+
+		close(resolveQueue)
+	}()
+
+	return resolveQueue
+}
+
+// Creates n scanners that take surviving partial-hash collisions from
+// resolveQueue and compute a full hash of their content, the final
+// authority on whether two files are actually identical.
+func createFullHashScanners(ctx context.Context, n int, resolveQueue chan fileContainer, sumQueue chan fileContainer, hashCtor func() hash.Hash, prog *progress) {
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case file, ok := <-resolveQueue:
+					if !ok {
+						return
+					}
+
+					fp, err := os.Open(file.path)
+					if err != nil {
+						fmt.Println("error opening file:", file)
+						fmt.Println(err)
+						continue // we proceede to next file on error
+					}
+
+					sum, err := hashFile(ctx, fp, hashCtor)
+					fp.Close()
+					if err != nil {
+						if err != context.Canceled {
+							fmt.Println("error creating hash for file:", file)
+							fmt.Println(err)
+						}
+						continue // we proceede to next file on error
+					}
+					prog.bytesHashed.Add(file.size)
+
+					file.hash = base64.StdEncoding.EncodeToString(sum)
+
+					select {
+					case <-ctx.Done():
+						return
+					case sumQueue <- file:
+					}
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()       // wait for all active scanners to finish
+		close(sumQueue) // close the channel of sums when done
+	}()
+}
+
+// hashFile reads r in chunks, checking ctx between reads, so a cancelled
+// scan stops part-way through a large file instead of hashing it to the end.
+func hashFile(ctx context.Context, r io.Reader, hashCtor func() hash.Hash) ([]byte, error) {
+	h := hashCtor()
+	buf := make([]byte, copyChunkSize)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		n, err := r.Read(buf)
+		if n > 0 {
+			h.Write(buf[:n])
+		}
+		if err == io.EOF {
+			return h.Sum(nil), nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// starts the function that holds the full hashes of files that survived
+// partial-hash collision. Once sumQueue is closed it emits one
+// duplicateGroup per colliding hash to reportQueue, and the duplicate/
+// original pairs within that group to dupeQueue. Having a single loop in a
+// single function removes data race.
+func startSumHolder(ctx context.Context, sumQueue chan fileContainer, dupeQueue chan dupePair, reportQueue chan duplicateGroup) {
+	go func() {
+		defer close(dupeQueue)
+		defer close(reportQueue)
+
+		sums := make(map[string][]fileContainer) // all files seen per hash
+		for container := range sumQueue {
+			sums[container.hash] = append(sums[container.hash], container)
+		}
+
+		for hash, group := range sums {
+			if len(group) < 2 {
+				continue // unique hash, not a duplicate
+			}
+
+			retained := group[0]
+			for _, container := range group[1:] {
+				if len(filepath.Dir(container.path)) < len(filepath.Dir(retained.path)) {
+					retained = container // keep the one with the shortest path
+				}
+			}
+
+			var duplicates []fileContainer
+			for _, container := range group {
+				if container.path != retained.path {
+					duplicates = append(duplicates, container)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case reportQueue <- duplicateGroup{
+				Hash:             hash,
+				Size:             retained.size,
+				Retained:         retained.path,
+				Duplicates:       pathsOf(duplicates),
+				ReclaimableBytes: retained.size * int64(len(duplicates)),
+			}:
+			}
+
+			for _, duplicate := range duplicates {
+				select {
+				case <-ctx.Done():
+					return
+				case dupeQueue <- dupePair{duplicate: duplicate, original: retained}:
+				}
+			}
+		}
+	}()
+}
+
+// pathsOf returns the paths of a slice of fileContainers, for reporting.
+func pathsOf(containers []fileContainer) []string {
+	paths := make([]string, len(containers))
+	for i, container := range containers {
+		paths[i] = container.path
+	}
+	return paths
+}
+
+// starts a loop that receives duplicate/original pairs and deletes the
+// duplicate.
+func startDeleter(ctx context.Context, dryRun bool, dupeQueue chan dupePair, deletedSumResult chan fileCounter) {
+	go func() {
+		var count, size int64 // for counting the deleted files
+		for pair := range dupeQueue {
+			count++
+			size += pair.duplicate.size
+			if !dryRun { // if we are actually doing it
+				fmt.Println("Deleting file:", pair.duplicate.path)
+				os.Remove(pair.duplicate.path)
+			} else { // if we are not actually deleting
+				fmt.Println("*Deleting file:", pair.duplicate.path)
+			}
+		}
+		// pump result to channel
+		deletedSumResult <- fileCounter{count: count, size: size}
+	}()
+}
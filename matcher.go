@@ -0,0 +1,168 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pattern is a single compiled -exclude/-include or .dfignore rule.
+type pattern struct {
+	negate   bool
+	dirOnly  bool
+	anchored bool   // had a '/' other than a trailing one, so it's matched against a relative path instead of just the basename
+	base     string // the directory (relative to the scan root, "" for the root itself) the pattern was declared in; an anchored pattern matches relative to this, not the scan root
+	glob     string
+}
+
+// compilePattern parses one gitignore-style pattern: a trailing '/' marks a
+// directory-only pattern, and a '/' anywhere else (including a leading one,
+// the usual gitignore idiom for "only right here") anchors the pattern to
+// the path it was declared relative to, instead of matching at any depth.
+// The leading '/' itself is just a marker and isn't part of the glob, so
+// it's stripped once anchoring has been decided.
+func compilePattern(raw string, negate bool) pattern {
+	dirOnly := strings.HasSuffix(raw, "/")
+	raw = strings.TrimSuffix(raw, "/")
+	anchored := strings.Contains(raw, "/")
+	raw = strings.TrimPrefix(raw, "/")
+	return pattern{negate: negate, dirOnly: dirOnly, anchored: anchored, glob: raw}
+}
+
+// parseIgnoreLine parses one line of a .dfignore file. It returns ok=false
+// for blank lines and comments.
+func parseIgnoreLine(line string) (pattern, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern{}, false
+	}
+
+	negate := strings.HasPrefix(line, "!")
+	if negate {
+		line = line[1:]
+	}
+
+	return compilePattern(line, negate), true
+}
+
+func (p pattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.anchored {
+		local := rel
+		if p.base != "" {
+			prefix := p.base + "/"
+			if !strings.HasPrefix(rel, prefix) {
+				return false // outside the directory this pattern was declared in
+			}
+			local = rel[len(prefix):]
+		}
+		return globMatch(p.glob, local)
+	}
+	return globMatch(p.glob, filepath.Base(rel))
+}
+
+// matcher is an ordered chain of patterns accumulated from .dfignore files
+// discovered root-to-leaf while walking. The last matching pattern wins,
+// same as gitignore.
+type matcher struct {
+	patterns []pattern
+}
+
+// excluded reports whether rel (a path relative to ".") should be skipped.
+// m's own patterns are checked first and cli last, so command-line
+// -exclude/-include flags always have the final say over any .dfignore.
+func (m *matcher) excluded(rel string, isDir bool, cli []pattern) bool {
+	rel = filepath.ToSlash(rel)
+
+	excluded := false
+	for _, p := range m.patterns {
+		if p.match(rel, isDir) {
+			excluded = !p.negate
+		}
+	}
+	for _, p := range cli {
+		if p.match(rel, isDir) {
+			excluded = !p.negate
+		}
+	}
+	return excluded
+}
+
+// withDfignore returns a matcher extended with the patterns from dir's own
+// .dfignore file, if any, so they apply to dir's descendants without
+// affecting sibling directories.
+func (m *matcher) withDfignore(dir string) *matcher {
+	data, err := os.ReadFile(filepath.Join(dir, ".dfignore"))
+	if err != nil {
+		return m
+	}
+
+	extended := make([]pattern, len(m.patterns), len(m.patterns)+8)
+	copy(extended, m.patterns)
+
+	base := ""
+	if dir != "." {
+		base = filepath.ToSlash(dir)
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if p, ok := parseIgnoreLine(line); ok {
+			p.base = base
+			extended = append(extended, p)
+		}
+	}
+
+	return &matcher{patterns: extended}
+}
+
+// globMatch reports whether name matches pattern, where pattern may use
+// "**" to match zero or more whole path segments, in addition to the usual
+// filepath.Match wildcards within a single segment.
+func globMatch(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pat, name []string) bool {
+	if len(pat) == 0 {
+		return len(name) == 0
+	}
+
+	if pat[0] == "**" {
+		if len(pat) == 1 {
+			return true
+		}
+		for i := 0; i <= len(name); i++ {
+			if matchSegments(pat[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(name) == 0 {
+		return false
+	}
+
+	ok, err := filepath.Match(pat[0], name[0])
+	if err != nil || !ok {
+		return false
+	}
+	return matchSegments(pat[1:], name[1:])
+}
+
+// patternFlag is a flag.Value that compiles each occurrence of -exclude or
+// -include with a fixed negate and appends it to a shared pattern slice, so
+// repeated flags accumulate in the order given on the command line.
+type patternFlag struct {
+	patterns *[]pattern
+	negate   bool
+}
+
+func (f *patternFlag) String() string { return "" }
+
+func (f *patternFlag) Set(raw string) error {
+	*f.patterns = append(*f.patterns, compilePattern(raw, f.negate))
+	return nil
+}
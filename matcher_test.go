@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestPatternMatch(t *testing.T) {
+	cases := []struct {
+		name  string
+		raw   string
+		rel   string
+		isDir bool
+		base  string
+		want  bool
+	}{
+		{name: "root anchor matches at root", raw: "/build", rel: "build", want: true},
+		{name: "root anchor does not match nested", raw: "/build", rel: "sub/build", want: false},
+		{name: "unanchored matches basename at any depth", raw: "build", rel: "sub/build", want: true},
+		{name: "nested anchor matches relative to base", raw: "/build", base: "sub", rel: "sub/build", want: true},
+		{name: "nested anchor outside its base doesn't match", raw: "/build", base: "sub", rel: "other/build", want: false},
+		{name: "dir-only skips files", raw: "build/", rel: "build", isDir: false, want: false},
+		{name: "dir-only matches directories", raw: "build/", rel: "build", isDir: true, want: true},
+		{name: "double-star matches across segments", raw: "**/*.log", rel: "a/b/c.log", want: true},
+		{name: "glob wildcard within a segment", raw: "*.tmp", rel: "dir/file.tmp", want: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			p := compilePattern(c.raw, false)
+			p.base = c.base
+			if got := p.match(c.rel, c.isDir); got != c.want {
+				t.Errorf("compilePattern(%q).match(%q, %v) = %v, want %v", c.raw, c.rel, c.isDir, got, c.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// progress holds the live, atomically-updated counters the progress
+// reporter polls. filesWalked grows as the walk discovers files.
+// bytesHashed grows as the partial and full hash passes read file content;
+// bytesToHash is the ETA's denominator, and only ever counts bytes that
+// will actually be hashed - a file's partial-hash cost once it's queued as
+// a same-size candidate, and its full size once it's queued again as a
+// partial-hash collision. Most walked bytes have a unique size or partial
+// hash and are never queued at all, so bytesToHash (not total walked
+// bytes) is what the ETA has to track for it to converge on a typical
+// tree. hashTotalKnown flips once both of those totals are fully known,
+// which happens when the collision resolver has seen every partial hash
+// result, well before hashing itself is done.
+type progress struct {
+	filesWalked    atomic.Int64
+	bytesHashed    atomic.Int64
+	bytesToHash    atomic.Int64
+	hashTotalKnown atomic.Bool
+}
+
+// startProgressReporter starts a goroutine that prints a snapshot of p
+// every interval, plus an immediate snapshot whenever sigCh fires (wired
+// to SIGUSR1 by the caller). It stops once ctx is done.
+func startProgressReporter(ctx context.Context, p *progress, interval time.Duration, sigCh <-chan os.Signal) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		start := time.Now()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				printSnapshot(p, start)
+			case <-sigCh:
+				printSnapshot(p, start)
+			}
+		}
+	}()
+}
+
+func printSnapshot(p *progress, start time.Time) {
+	elapsed := time.Since(start)
+	filesWalked := p.filesWalked.Load()
+	bytesToHash := p.bytesToHash.Load()
+	bytesHashed := p.bytesHashed.Load()
+	throughput := float64(bytesHashed) / elapsed.Seconds()
+
+	fmt.Printf("[progress] %d files walked, %.1f MB hashed, %.1f MB/s", filesWalked, float64(bytesHashed)/1e6, throughput/1e6)
+
+	if p.hashTotalKnown.Load() && throughput > 0 {
+		remaining := bytesToHash - bytesHashed
+		if remaining < 0 {
+			remaining = 0
+		}
+		eta := time.Duration(float64(remaining)/throughput) * time.Second
+		fmt.Printf(", ETA %s", eta.Round(time.Second))
+	}
+
+	fmt.Println()
+}
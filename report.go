@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// duplicateGroup is a single NDJSON record written to a -report manifest:
+// the retained file, the duplicates found for it, and how much space they
+// take up.
+type duplicateGroup struct {
+	Hash             string   `json:"hash"`
+	Size             int64    `json:"size"`
+	Retained         string   `json:"retained"`
+	Duplicates       []string `json:"duplicates"`
+	ReclaimableBytes int64    `json:"reclaimable_bytes"`
+}
+
+// starts a goroutine that serializes duplicate groups from reportQueue as
+// newline-delimited JSON to path, sending the final error (nil on success)
+// on done once reportQueue is closed. If path is empty the groups are
+// drained and discarded so the sum holder is never blocked sending them.
+func startReporter(reportQueue chan duplicateGroup, path string, done chan error) {
+	go func() {
+		if path == "" {
+			for range reportQueue {
+			}
+			done <- nil
+			return
+		}
+
+		f, err := os.Create(path)
+		if err != nil {
+			for range reportQueue {
+			}
+			done <- err
+			return
+		}
+		defer f.Close()
+
+		enc := json.NewEncoder(f)
+		for group := range reportQueue {
+			if err := enc.Encode(group); err != nil {
+				fmt.Println("error writing report:", err)
+			}
+		}
+		done <- nil
+	}()
+}
+
+// runApply re-runs deletions (or, with linkMode, hardlinks) recorded in an
+// NDJSON manifest previously written with -report, without rescanning the
+// tree. This lets a manifest be reviewed before anything is actually
+// removed. Linking goes through the same linkDuplicate used by -link's live
+// path, so a replayed manifest gets the same crash-safe rename-over-temp
+// swap instead of a remove-then-create that could lose a file.
+func runApply(path string, dryRun bool, linkMode bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var count, size int64
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var group duplicateGroup
+		if err := dec.Decode(&group); err != nil {
+			return err
+		}
+
+		for _, dup := range group.Duplicates {
+			if linkMode && sameFile(dup, group.Retained) {
+				continue // already hardlinked, nothing to reclaim
+			}
+
+			count++
+			size += group.Size
+
+			if dryRun {
+				if linkMode {
+					fmt.Println("*Linking file:", dup)
+				} else {
+					fmt.Println("*Deleting file:", dup)
+				}
+				continue
+			}
+
+			if linkMode {
+				if err := linkDuplicate(dup, group.Retained); err != nil {
+					fmt.Println("error linking duplicate:", dup)
+					fmt.Println(err)
+					count--
+					size -= group.Size
+				}
+			} else {
+				fmt.Println("Deleting file:", dup)
+				os.Remove(dup)
+			}
+		}
+	}
+
+	fmt.Println("Applied files:", count)
+	fmt.Println("Applied size:", size)
+	return nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// captureStdout runs fn with os.Stdout redirected to a pipe and returns
+// everything it printed, so tests can assert on runApply's tally output
+// without changing its signature.
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	orig := os.Stdout
+	os.Stdout = w
+
+	fnErr := fn()
+
+	w.Close()
+	os.Stdout = orig
+
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out), fnErr
+}
+
+func TestReportRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	dup := filepath.Join(dir, "dup")
+	if err := os.WriteFile(dup, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	reportPath := filepath.Join(dir, "report.ndjson")
+	queue := make(chan duplicateGroup, 1)
+	done := make(chan error, 1)
+	startReporter(queue, reportPath, done)
+	queue <- duplicateGroup{
+		Hash:             "deadbeef",
+		Size:             7,
+		Retained:         filepath.Join(dir, "original"),
+		Duplicates:       []string{dup},
+		ReclaimableBytes: 7,
+	}
+	close(queue)
+	if err := <-done; err != nil {
+		t.Fatalf("startReporter: %v", err)
+	}
+
+	out, err := captureStdout(t, func() error { return runApply(reportPath, true, false) })
+	if err != nil {
+		t.Fatalf("dry-run apply: %v", err)
+	}
+	if _, err := os.Stat(dup); err != nil {
+		t.Fatalf("dry-run apply should not have removed %s: %v", dup, err)
+	}
+	if !strings.Contains(out, "Applied files: 1") || !strings.Contains(out, "Applied size: 7") {
+		t.Fatalf("dry-run apply should still tally the would-be reclaim, got:\n%s", out)
+	}
+
+	if err := runApply(reportPath, false, false); err != nil {
+		t.Fatalf("apply: %v", err)
+	}
+	if _, err := os.Stat(dup); !os.IsNotExist(err) {
+		t.Fatalf("apply should have removed %s, stat err: %v", dup, err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// walkOptions configures which files createWalker's traversal considers.
+type walkOptions struct {
+	matcher        *matcher
+	cliPatterns    []pattern
+	minSize        int64
+	maxSize        int64
+	followSymlinks bool
+	progress       *progress
+}
+
+// Creates the walk function that traverses the tree rooted at ".",
+// filtering out files and directories per opts (exclude/include patterns,
+// .dfignore files, size bounds), and sending surviving files to
+// scanningQueue.
+func createWalker(ctx context.Context, opts walkOptions, scanningQueue chan fileContainer) func() error {
+	return func() error {
+		return walkDir(ctx, ".", opts.matcher, opts, make(map[string]bool), scanningQueue)
+	}
+}
+
+func walkDir(ctx context.Context, dir string, m *matcher, opts walkOptions, visitedDirs map[string]bool, scanningQueue chan fileContainer) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	dirMatcher := m.withDfignore(dir)
+
+	for _, entry := range entries {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		path := filepath.Join(dir, entry.Name())
+
+		info, err := entry.Info()
+		if err != nil {
+			fmt.Println("error reading:", path)
+			fmt.Println(err)
+			continue
+		}
+
+		isSymlink := info.Mode()&os.ModeSymlink != 0
+		if isSymlink {
+			resolved, err := os.Stat(path) // follow the link to see what it points to
+			if err != nil {
+				continue // broken symlink, skip it
+			}
+			if resolved.IsDir() && !opts.followSymlinks {
+				continue // don't recurse into symlinked directories unless asked
+			}
+			info = resolved
+		}
+
+		if info.IsDir() {
+			if dirMatcher.excluded(path, true, opts.cliPatterns) {
+				continue
+			}
+
+			if isSymlink {
+				key, err := dirIdentity(path)
+				if err != nil {
+					continue
+				}
+				if visitedDirs[key] {
+					continue // already visited this directory, avoid a symlink cycle
+				}
+				visitedDirs[key] = true
+			}
+
+			if err := walkDir(ctx, path, dirMatcher, opts, visitedDirs, scanningQueue); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if dirMatcher.excluded(path, false, opts.cliPatterns) {
+			continue
+		}
+		if opts.minSize > 0 && info.Size() < opts.minSize {
+			continue
+		}
+		if opts.maxSize > 0 && info.Size() > opts.maxSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case scanningQueue <- fileContainer{path: path, size: info.Size()}:
+			opts.progress.filesWalked.Add(1)
+		}
+	}
+
+	return nil
+}
+
+// dirIdentity returns a key identifying the filesystem object at path, so
+// walkDir can recognize a directory it has already visited through a
+// different symlink before following it again.
+func dirIdentity(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("cannot determine identity of %s", path)
+	}
+	return fmt.Sprintf("%d:%d", stat.Dev, stat.Ino), nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func walkPaths(t *testing.T, root string, followSymlinks bool) []string {
+	t.Helper()
+
+	opts := walkOptions{
+		matcher:        &matcher{},
+		followSymlinks: followSymlinks,
+		progress:       &progress{},
+	}
+
+	scanningQueue := make(chan fileContainer)
+	var got []string
+	done := make(chan struct{})
+	go func() {
+		for c := range scanningQueue {
+			got = append(got, c.path)
+		}
+		close(done)
+	}()
+
+	orig, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(root); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(orig)
+
+	err = walkDir(context.Background(), ".", opts.matcher, opts, make(map[string]bool), scanningQueue)
+	close(scanningQueue)
+	<-done
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(got)
+	return got
+}
+
+func TestWalkDirFollowsFileSymlinksByDefault(t *testing.T) {
+	root := t.TempDir()
+	target := filepath.Join(root, "real.txt")
+	if err := os.WriteFile(target, []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(root, "link.txt")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatal(err)
+	}
+
+	got := walkPaths(t, root, false)
+	want := []string{"link.txt", "real.txt"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("walkDir(followSymlinks=false) = %v, want %v (symlinked files should always be scanned)", got, want)
+	}
+}
+
+func TestWalkDirSkipsSymlinkedDirsUnlessFollowed(t *testing.T) {
+	root := t.TempDir()
+	realDir := filepath.Join(root, "realdir")
+	if err := os.Mkdir(realDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(realDir, "inside.txt"), []byte("content"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	linkDir := filepath.Join(root, "linkdir")
+	if err := os.Symlink(realDir, linkDir); err != nil {
+		t.Fatal(err)
+	}
+
+	got := walkPaths(t, root, false)
+	if len(got) != 1 || got[0] != "realdir/inside.txt" {
+		t.Fatalf("walkDir(followSymlinks=false) = %v, want only the real directory's contents", got)
+	}
+
+	got = walkPaths(t, root, true)
+	want := []string{"linkdir/inside.txt", "realdir/inside.txt"}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("walkDir(followSymlinks=true) = %v, want %v", got, want)
+	}
+}